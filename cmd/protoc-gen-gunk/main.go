@@ -0,0 +1,55 @@
+// Command protoc-gen-gunk is a protoc plugin that converts .proto files to
+// .gunk. Unlike "gunk convert", which parses .proto text itself, this
+// plugin is driven by protoc, so it works from the same fully-resolved
+// descriptors protoc builds for every plugin, extensions such as
+// (google.api.http) included. Type references are written without their
+// proto package, and gogoproto field options are not converted.
+//
+// Build it onto $PATH and invoke it through protoc:
+//
+//	protoc --gunk_out=. foo.proto
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/gunk/gunk/convert"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "protoc-gen-gunk:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	in, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("unable to read request from stdin: %v", err)
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(in, req); err != nil {
+		return fmt.Errorf("unable to parse code generator request: %v", err)
+	}
+
+	resp, err := convert.RunPlugin(req)
+	if err != nil {
+		return err
+	}
+
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("unable to marshal code generator response: %v", err)
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		return fmt.Errorf("unable to write response to stdout: %v", err)
+	}
+	return nil
+}
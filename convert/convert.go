@@ -32,6 +32,53 @@ type builder struct {
 	// Imports that are required to ro generate a valid Gunk file.
 	// Mostly these will be Gunk annotations.
 	importsUsed map[string]bool
+
+	// The google/protobuf/*.proto files whose well-known types were
+	// fully translated to a Go equivalent via wellKnownTypes. Their
+	// "import" statements can be dropped instead of being carried over
+	// as comments in handleImports.
+	translatedWellKnownProtoFiles map[string]bool
+}
+
+// wellKnownType describes how a well-known protobuf type - one of the
+// types declared in the google/protobuf/*.proto files shipped with
+// protoc - is translated to gunk.
+type wellKnownType struct {
+	// goType is the Go type to use in place of the proto type name.
+	goType string
+	// importPath is added to importsUsed if non-empty.
+	importPath string
+	// protoFile is the google/protobuf/*.proto file that declares the
+	// type, so its proto import can be dropped once translated.
+	protoFile string
+	// empty marks google.protobuf.Empty, which handleService treats as
+	// "no parameter" rather than substituting goType.
+	empty bool
+}
+
+// protoMaxFieldNumber is the highest field number proto3 allows, used as
+// the upper bound of an open-ended "reserved N to max;" range.
+const protoMaxFieldNumber = 536870911
+
+var wellKnownTypes = map[string]wellKnownType{
+	"google.protobuf.Timestamp": {goType: "time.Time", importPath: "time", protoFile: "google/protobuf/timestamp.proto"},
+	"google.protobuf.Duration":  {goType: "time.Duration", importPath: "time", protoFile: "google/protobuf/duration.proto"},
+	// handleService special-cases Empty as "no parameter" rather than
+	// using goType, but a message can still reference it as an ordinary
+	// field, so it needs a real type rather than an anonymous struct{}.
+	"google.protobuf.Empty": {goType: "*emptypb.Empty", importPath: "google.golang.org/protobuf/types/known/emptypb", protoFile: "google/protobuf/empty.proto", empty: true},
+	"google.protobuf.Struct": {goType: "*structpb.Struct", importPath: "google.golang.org/protobuf/types/known/structpb", protoFile: "google/protobuf/struct.proto"},
+	"google.protobuf.Any":    {goType: "anypb.Any", importPath: "google.golang.org/protobuf/types/known/anypb", protoFile: "google/protobuf/any.proto"},
+
+	"google.protobuf.StringValue": {goType: "*string", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.BytesValue":  {goType: "*[]byte", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.Int32Value":  {goType: "*int32", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.Int64Value":  {goType: "*int64", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.UInt32Value": {goType: "*uint32", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.UInt64Value": {goType: "*uint64", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.FloatValue":  {goType: "*float32", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.DoubleValue": {goType: "*float64", protoFile: "google/protobuf/wrappers.proto"},
+	"google.protobuf.BoolValue":   {goType: "*bool", protoFile: "google/protobuf/wrappers.proto"},
 }
 
 // Run converts proto files or folders to gunk files, saving the files in
@@ -103,8 +150,9 @@ func convertFile(path string, overwrite bool) error {
 
 	// Start converting the proto declarations to gunk.
 	b := builder{
-		filename:    filename,
-		importsUsed: map[string]bool{},
+		filename:                      filename,
+		importsUsed:                   map[string]bool{},
+		translatedWellKnownProtoFiles: map[string]bool{},
 	}
 	for _, e := range d.Elements {
 		if err := b.handleProtoType(e); err != nil {
@@ -213,6 +261,15 @@ func (b *builder) goType(fieldType string) string {
 	case "uint64", "fixed64":
 		return "uint64"
 	default:
+		// Check if this is one of the well-known types from
+		// google/protobuf/*.proto before assuming it's a custom type.
+		if wkt, ok := wellKnownTypes[fieldType]; ok {
+			if wkt.importPath != "" {
+				b.importsUsed[wkt.importPath] = true
+			}
+			b.translatedWellKnownProtoFiles[wkt.protoFile] = true
+			return wkt.goType
+		}
 		// This is either an unrecognised type, or a custom type.
 		return fieldType
 	}
@@ -247,6 +304,60 @@ func (b *builder) handleProtoType(typ proto.Visitee) error {
 	return err
 }
 
+// isScalarGoType reports whether typ is one of the Go types goType returns
+// for a proto scalar, as opposed to a slice, map or custom message type.
+// Only these can sensibly be turned into pointers for a proto2 "optional"
+// field.
+func (b *builder) isScalarGoType(typ string) bool {
+	switch typ {
+	case "bool", "string", "float64", "float32", "int", "int32", "int64", "uint32", "uint64":
+		return true
+	}
+	return false
+}
+
+// gogoFieldOptions holds the gogoproto field extensions that affect how a
+// field is emitted, pulled out of a field's options by
+// parseGogoFieldOptions.
+type gogoFieldOptions struct {
+	castType   string
+	customType string
+	embed      bool
+	nullable   *bool
+	hasJSONTag bool
+	jsonTag    string
+	moreTags   string
+}
+
+// parseGogoFieldOptions pulls the gogoproto extensions out of a field's
+// options, returning them alongside whatever options are left over (so
+// the caller can still report or otherwise handle proto2's "default").
+func (b *builder) parseGogoFieldOptions(options []*proto.Option) (gogoFieldOptions, []*proto.Option) {
+	var g gogoFieldOptions
+	var remaining []*proto.Option
+	for _, o := range options {
+		switch o.Name {
+		case "(gogoproto.casttype)":
+			g.castType, _ = b.handleLiteralString(o.Constant)
+		case "(gogoproto.customtype)":
+			g.customType, _ = b.handleLiteralString(o.Constant)
+		case "(gogoproto.embed)":
+			g.embed = o.Constant.Source == "true"
+		case "(gogoproto.nullable)":
+			nullable := o.Constant.Source == "true"
+			g.nullable = &nullable
+		case "(gogoproto.jsontag)":
+			g.jsonTag, _ = b.handleLiteralString(o.Constant)
+			g.hasJSONTag = true
+		case "(gogoproto.moretags)":
+			g.moreTags, _ = b.handleLiteralString(o.Constant)
+		default:
+			remaining = append(remaining, o)
+		}
+	}
+	return g, remaining
+}
+
 // handleMessageField will convert a messages field to gunk.
 func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) error {
 	var (
@@ -254,6 +365,7 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		typ      string
 		sequence int
 		repeated bool
+		optional bool
 		comment  *proto.Comment
 		options  []*proto.Option
 	)
@@ -266,6 +378,7 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		sequence = ft.Sequence
 		comment = ft.Comment
 		repeated = ft.Repeated
+		optional = ft.Optional
 		options = ft.Options
 	case *proto.MapField:
 		ft := field.(*proto.MapField)
@@ -280,27 +393,167 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		return fmt.Errorf("unhandled message field type %T", field)
 	}
 
-	if repeated {
-		typ = "[]" + typ
+	gogoOpts, options := b.parseGogoFieldOptions(options)
+
+	// customtype/casttype replace the element type itself, so they're
+	// applied before the repeated/optional/nullable wrapping below -
+	// otherwise a repeated customtype field would lose its "[]".
+	if gogoOpts.customType != "" {
+		typ = gogoOpts.customType
+	} else if gogoOpts.castType != "" {
+		typ = gogoOpts.castType
 	}
 
+	if repeated {
+		typ = "[]" + typ
+	} else if optional && b.isScalarGoType(typ) {
+		// proto2 "optional" scalars don't have a zero-value-means-unset
+		// convention like proto3, so they need to be pointers to be able
+		// to tell "unset" apart from the zero value.
+		typ = "*" + typ
+	} else if gogoOpts.nullable != nil && *gogoOpts.nullable && !b.isScalarGoType(typ) && typ != "[]byte" {
+		// gogoproto already treats message-type fields as nullable (a
+		// pointer) by default; an explicit nullable=true only matters to
+		// override a file-level nullable_all=false.
+		typ = "*" + typ
+	}
+
+	// A proto2 "default" option becomes a +gunk field.Default() annotation
+	// rather than a struct tag, since gunk doesn't have a field option
+	// syntax of its own yet.
+	var defaultOpt *proto.Option
+	var remaining []*proto.Option
 	for _, o := range options {
+		if o.Name == "default" {
+			defaultOpt = o
+			continue
+		}
+		remaining = append(remaining, o)
+	}
+	for _, o := range remaining {
 		fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled field option %q", o.Name))
 	}
 
+	if defaultOpt != nil {
+		if comment != nil {
+			b.format(w, 1, comment, "//\n")
+			comment = nil
+		}
+		if defaultOpt.Constant.IsString {
+			b.format(w, 1, nil, "// +gunk field.Default(%q)\n", defaultOpt.Constant.Source)
+		} else {
+			b.format(w, 1, nil, "// +gunk field.Default(%s)\n", defaultOpt.Constant.Source)
+		}
+		b.importsUsed["github.com/gunk/opt/field"] = true
+	}
+
+	// gogoproto.jsontag overrides the json struct tag, and moretags is
+	// appended to the tag verbatim instead of being reported as unhandled.
+	jsonTag := snaker.CamelToSnake(name)
+	if gogoOpts.hasJSONTag {
+		jsonTag = gogoOpts.jsonTag
+	}
+	tag := fmt.Sprintf("pb:\"%d\" json:\"%s\"", sequence, jsonTag)
+	if gogoOpts.moreTags != "" {
+		tag += " " + gogoOpts.moreTags
+	}
+
 	// TODO(vishen): Is this correct to explicitly camelcase the variable name and
 	// snakecase the json name???
 	// If we do, gunk should probably have an option to set the variable name
 	// in the proto to something else? That way we can use best practises for
 	// each language???
-	b.format(w, 1, comment, "%s %s", snaker.ForceCamelIdentifier(name), typ)
-	b.format(w, 0, nil, " `pb:\"%d\" json:\"%s\"`\n", sequence, snaker.CamelToSnake(name))
+	if gogoOpts.embed {
+		// An embedded field is anonymous, so it only has a type.
+		b.format(w, 1, comment, "%s", typ)
+	} else {
+		b.format(w, 1, comment, "%s %s", snaker.ForceCamelIdentifier(name), typ)
+	}
+	b.format(w, 0, nil, " `%s`\n", tag)
 	return nil
 }
 
+// handleReserved converts a proto `reserved` statement, which has no
+// field of its own to attach to, into a +gunk message.Reserved{} annotation
+// above the message it belongs to.
+func (b *builder) handleReserved(w *strings.Builder, r *proto.Reserved) {
+	ranges := make([]string, 0, len(r.Ranges))
+	for _, rg := range r.Ranges {
+		to := rg.To
+		switch {
+		case rg.Max:
+			// "reserved N to max;" - emicklei/proto leaves To unset and
+			// sets Max instead, so substitute the highest field number
+			// proto3 allows rather than collapsing the range to {N, N}.
+			to = protoMaxFieldNumber
+		case to == 0:
+			to = rg.From
+		}
+		ranges = append(ranges, fmt.Sprintf("{%d, %d}", rg.From, to))
+	}
+	names := make([]string, 0, len(r.FieldNames))
+	for _, n := range r.FieldNames {
+		names = append(names, fmt.Sprintf("%q", n))
+	}
+
+	b.format(w, 0, r.Comment, "// +gunk message.Reserved{\n")
+	if len(ranges) > 0 {
+		b.format(w, 0, nil, "//     Ranges: [][2]int{%s},\n", strings.Join(ranges, ", "))
+	}
+	if len(names) > 0 {
+		b.format(w, 0, nil, "//     Names: []string{%s},\n", strings.Join(names, ", "))
+	}
+	b.format(w, 0, nil, "// }\n")
+	b.importsUsed["github.com/gunk/opt/message"] = true
+}
+
+// handleOneof converts a proto oneof into the same pattern protoc-gen-go
+// uses: an interface with an unexported marker method, and one wrapper
+// struct per oneof field that implements it. It returns the interface
+// name, which the caller uses as the type of the field on the parent
+// message.
+func (b *builder) handleOneof(messageName string, o *proto.Oneof) (string, error) {
+	camelName := snaker.ForceCamelIdentifier(o.Name)
+	ifaceName := fmt.Sprintf("Is%s_%s", messageName, camelName)
+	markerMethod := fmt.Sprintf("is%s_%s", messageName, camelName)
+
+	iw := &strings.Builder{}
+	b.format(iw, 0, o.Comment, "type %s interface {\n", ifaceName)
+	b.format(iw, 1, nil, "%s()\n", markerMethod)
+	b.format(iw, 0, nil, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, iw.String())
+
+	for _, e := range o.Elements {
+		f, ok := e.(*proto.OneOfField)
+		if !ok {
+			return "", b.formatError(o.Position, "unexpected type %T in oneof, expected field", e)
+		}
+		wrapperName := fmt.Sprintf("%s_%s", messageName, snaker.ForceCamelIdentifier(f.Name))
+
+		ww := &strings.Builder{}
+		b.format(ww, 0, f.Comment, "type %s struct {\n", wrapperName)
+		b.format(ww, 1, nil, "%s %s", snaker.ForceCamelIdentifier(f.Name), b.goType(f.Type))
+		b.format(ww, 0, nil, " `pb:\"%d\" json:\"%s\"`\n", f.Sequence, snaker.CamelToSnake(f.Name))
+		b.format(ww, 0, nil, "}\n\n")
+		b.format(ww, 0, nil, "func (*%s) %s() {}", wrapperName, markerMethod)
+		b.translatedDeclarations = append(b.translatedDeclarations, ww.String())
+	}
+	return ifaceName, nil
+}
+
 // handleMessage will convert a proto message to Gunk.
 func (b *builder) handleMessage(m *proto.Message) error {
 	w := &strings.Builder{}
+
+	// Reserved ranges/names are a gunk annotation that has to be written
+	// above the message's doc comment and type declaration, so collect
+	// them before we write anything else.
+	for _, e := range m.Elements {
+		if r, ok := e.(*proto.Reserved); ok {
+			b.handleReserved(w, r)
+		}
+	}
+
 	b.format(w, 0, m.Comment, "type %s struct {\n", m.Name)
 	for _, e := range m.Elements {
 		switch e.(type) {
@@ -323,7 +576,23 @@ func (b *builder) handleMessage(m *proto.Message) error {
 			}
 		case *proto.Option:
 			o := e.(*proto.Option)
+			// gogoproto message-level extensions (equal_all, populate_all,
+			// and the like) only affect generated marshal/compare code, which
+			// gunk has no equivalent of, so there's nothing to translate.
+			if strings.HasPrefix(o.Name, "(gogoproto.") {
+				continue
+			}
 			fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled message option %q", o.Name))
+		case *proto.Oneof:
+			o := e.(*proto.Oneof)
+			ifaceName, err := b.handleOneof(m.Name, o)
+			if err != nil {
+				return err
+			}
+			b.format(w, 1, o.Comment, "%s %s", snaker.ForceCamelIdentifier(o.Name), ifaceName)
+			b.format(w, 0, nil, " `pb:\"oneof\"`\n")
+		case *proto.Reserved:
+			// Already handled above, as a gunk annotation on the message.
 		default:
 			return b.formatError(m.Position, "unexpected type %T in message", e)
 		}
@@ -397,6 +666,70 @@ func (b *builder) handleEnum(e *proto.Enum) error {
 	return nil
 }
 
+// httpMatch holds a single HTTP binding extracted from a (google.api.http)
+// option, including any binding added through "additional_bindings".
+type httpMatch struct {
+	method string
+	url    string
+	body   string
+}
+
+// handleHTTPRule walks the fields of a (google.api.http) option literal and
+// returns one httpMatch per binding. It recurses into "additional_bindings"
+// so a single rpc can carry more than one HTTP mapping, and understands the
+// "custom" sub-map used for verbs that aren't one of the get/put/post/delete/
+// patch keywords.
+func (b *builder) handleHTTPRule(pos scanner.Position, fields []*proto.NamedLiteral) ([]httpMatch, error) {
+	var (
+		match   httpMatch
+		matches []httpMatch
+		err     error
+	)
+	for _, l := range fields {
+		switch n := l.Name; n {
+		case "body":
+			match.body, err = b.handleLiteralString(*l.Literal)
+			if err != nil {
+				return nil, b.formatError(pos, "option for body should be a string")
+			}
+		case "additional_bindings":
+			if len(l.Literal.OrderedMap) == 0 {
+				return nil, b.formatError(pos, "expected additional_bindings to be a map")
+			}
+			extra, err := b.handleHTTPRule(pos, l.Literal.OrderedMap)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, extra...)
+		case "custom":
+			if len(l.Literal.OrderedMap) == 0 {
+				return nil, b.formatError(pos, "expected custom to be a map")
+			}
+			for _, c := range l.Literal.OrderedMap {
+				switch c.Name {
+				case "kind":
+					match.method, err = b.handleLiteralString(*c.Literal)
+				case "path":
+					match.url, err = b.handleLiteralString(*c.Literal)
+				}
+				if err != nil {
+					return nil, b.formatError(pos, "option for custom.%s should be a string", c.Name)
+				}
+			}
+		default:
+			match.method = n
+			match.url, err = b.handleLiteralString(*l.Literal)
+			if err != nil {
+				return nil, b.formatError(pos, "option for %q should be a string (url)", n)
+			}
+		}
+	}
+	if match.method != "" && match.url != "" {
+		matches = append([]httpMatch{match}, matches...)
+	}
+	return matches, nil
+}
+
 func (b *builder) handleService(s *proto.Service) error {
 	w := &strings.Builder{}
 	b.format(w, 0, s.Comment, "type %s interface {\n", s.Name)
@@ -431,44 +764,32 @@ func (b *builder) handleService(s *proto.Service) error {
 			}
 			switch n := opt.Name; n {
 			case "(google.api.http)":
-				var err error
-				method := ""
-				url := ""
-				body := ""
 				literal := opt.Constant
 				if len(literal.OrderedMap) == 0 {
 					return b.formatError(opt.Position, "expected option to be a map")
 				}
-				for _, l := range literal.OrderedMap {
-					switch n := l.Name; n {
-					case "body":
-						body, err = b.handleLiteralString(*l.Literal)
-						if err != nil {
-							return b.formatError(opt.Position, "option for body should be a string")
-						}
-					default:
-						method = n
-						url, err = b.handleLiteralString(*l.Literal)
-						if err != nil {
-							return b.formatError(opt.Position, "option for %q should be a string (url)", method)
-						}
-					}
+				matches, err := b.handleHTTPRule(opt.Position, literal.OrderedMap)
+				if err != nil {
+					return err
 				}
 
 				// Check if we received a valid google http annotation. If
-				// so we will convert it to gunk http match.
-				if method != "" && url != "" {
+				// so we will convert it to one or more gunk http matches,
+				// one per binding (including any additional_bindings).
+				if len(matches) > 0 {
 					if comment != nil {
 						b.format(w, 1, comment, "//\n")
 						comment = nil
 					}
-					b.format(w, 1, nil, "// +gunk http.Match{\n")
-					b.format(w, 1, nil, "//     Method: %q,\n", strings.ToUpper(method))
-					b.format(w, 1, nil, "//     Path: %q,\n", url)
-					if body != "" {
-						b.format(w, 1, nil, "//     Body: %q,\n", body)
+					for _, m := range matches {
+						b.format(w, 1, nil, "// +gunk http.Match{\n")
+						b.format(w, 1, nil, "//     Method: %q,\n", strings.ToUpper(m.method))
+						b.format(w, 1, nil, "//     Path: %q,\n", m.url)
+						if m.body != "" {
+							b.format(w, 1, nil, "//     Body: %q,\n", m.body)
+						}
+						b.format(w, 1, nil, "// }\n")
 					}
-					b.format(w, 1, nil, "// }\n")
 					b.importsUsed["github.com/gunk/opt/http"] = true
 				}
 			default:
@@ -476,15 +797,41 @@ func (b *builder) handleService(s *proto.Service) error {
 			}
 		}
 		// If the request type is the known empty parameter we can convert
-		// this to gunk as an empty function parameter.
+		// this to gunk as an empty function parameter. This uses the same
+		// wellKnownTypes table as goType, so google.protobuf.Empty is
+		// recognised consistently wherever it shows up.
 		requestType := r.RequestType
 		returnsType := r.ReturnsType
-		if requestType == "google.protobuf.Empty" {
+		if wkt, ok := wellKnownTypes[requestType]; ok && wkt.empty {
+			b.translatedWellKnownProtoFiles[wkt.protoFile] = true
 			requestType = ""
 		}
-		if returnsType == "google.protobuf.Empty" {
+		if wkt, ok := wellKnownTypes[returnsType]; ok && wkt.empty {
+			b.translatedWellKnownProtoFiles[wkt.protoFile] = true
 			returnsType = ""
 		}
+
+		// A streaming rpc needs a +gunk grpc.Stream{} annotation so the
+		// gunk generator knows which side(s) of the call are streams, as
+		// well as chan-typed parameters so the Go signature reflects it.
+		if r.StreamsRequest || r.StreamsReturns {
+			if comment != nil {
+				b.format(w, 1, comment, "//\n")
+				comment = nil
+			}
+			b.format(w, 1, nil, "// +gunk grpc.Stream{\n")
+			b.format(w, 1, nil, "//     Request: %t,\n", r.StreamsRequest)
+			b.format(w, 1, nil, "//     Returns: %t,\n", r.StreamsReturns)
+			b.format(w, 1, nil, "// }\n")
+			b.importsUsed["github.com/gunk/opt/grpc"] = true
+
+			if r.StreamsRequest && requestType != "" {
+				requestType = "chan " + requestType
+			}
+			if r.StreamsReturns && returnsType != "" {
+				returnsType = "chan " + returnsType
+			}
+		}
 		b.format(w, 1, comment, "%s(%s) %s\n", r.Name, requestType, returnsType)
 	}
 	b.format(w, 0, nil, "}")
@@ -550,6 +897,12 @@ func (b *builder) handlePackage() (string, error) {
 		case "cc_enable_arenas":
 			impt = "github.com/gunk/opt/file/cc"
 			value = b.genAnnotation("EnableArenas", val)
+		case "(gogoproto.marshaler_all)":
+			impt = "github.com/gunk/opt/gogo"
+			value = b.genAnnotation("MarshalerAll", "")
+		case "(gogoproto.unmarshaler_all)":
+			impt = "github.com/gunk/opt/gogo"
+			value = b.genAnnotation("UnmarshalerAll", "")
 		default:
 			return "", b.formatError(o.Position, "%q is an unhandled proto file option", n)
 		}
@@ -592,8 +945,12 @@ func (b *builder) handleImports() string {
 		b.format(w, 1, nil, fmt.Sprintf("%q", i))
 	}
 
-	// Add any proto imports as comments.
+	// Add any proto imports as comments, skipping the well-known-type
+	// imports that were fully translated to a Go equivalent above.
 	for _, i := range b.imports {
+		if b.translatedWellKnownProtoFiles[i.Filename] {
+			continue
+		}
 		b.format(w, 0, nil, "\n")
 		b.format(w, 1, nil, "// %q", i.Filename)
 	}
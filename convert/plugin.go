@@ -0,0 +1,487 @@
+package convert
+
+import (
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/knq/snaker"
+)
+
+// This file adds a second, descriptor-driven front-end to convert. Unlike
+// convertFile, which drives the best-effort emicklei/proto text parser and
+// so silently drops anything outside its grammar, RunPlugin and
+// RunDescriptorSet work from the same fully resolved FileDescriptorProto
+// that protoc builds for every protoc-gen-* plugin, so package-qualified
+// type references, protobuf-go extensions (like (google.api.http)),
+// defaults, and reserved/extension ranges are all available. This is the
+// same design protoc-gen-go and protoc-gen-micro use.
+//
+// gogoproto field options (customtype, casttype, embed, nullable,
+// jsontag, moretags) are the one exception: they're registered as
+// extensions of gogo's own descriptor.FieldOptions, not the
+// descriptorpb.FieldOptions protoc hands every plugin, so there's no way
+// to read them off a FileDescriptorProto here. convertFile's text-parser
+// front-end is the only one that understands them.
+
+// goTypeFromDescriptor turns a field's descriptor type into a Go type,
+// the descriptor-driven equivalent of goType.
+func (b *builder) goTypeFromDescriptor(f *descriptorpb.FieldDescriptorProto) string {
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return "bool"
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return "string"
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return "[]byte"
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return "float64"
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		return "float32"
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32:
+		return "int"
+	case descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		return "int32"
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return "int64"
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		return "uint32"
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return "uint64"
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return b.goTypeName(f.GetTypeName())
+	default:
+		return f.GetTypeName()
+	}
+}
+
+// goTypeName turns a fully package-qualified descriptor type name, such as
+// ".foo.bar.Baz", into the Go identifier convert uses for it: well-known
+// types go through the same wellKnownTypes table as goType, and everything
+// else has its package path stripped, since gunk generates one package per
+// proto package and so never needs to qualify a same-package reference.
+func (b *builder) goTypeName(name string) string {
+	trimmed := strings.TrimPrefix(name, ".")
+	if wkt, ok := wellKnownTypes[trimmed]; ok {
+		if wkt.importPath != "" {
+			b.importsUsed[wkt.importPath] = true
+		}
+		b.translatedWellKnownProtoFiles[wkt.protoFile] = true
+		return wkt.goType
+	}
+	parts := strings.Split(trimmed, ".")
+	return parts[len(parts)-1]
+}
+
+// isEmptyTypeName reports whether name (a package-qualified descriptor
+// type name) is google.protobuf.Empty.
+func isEmptyTypeName(name string) bool {
+	wkt, ok := wellKnownTypes[strings.TrimPrefix(name, ".")]
+	return ok && wkt.empty
+}
+
+// mapEntryDescriptor returns the synthetic "FooEntry" nested message
+// protoc generates for a map<K, V> field, or nil if typeName doesn't name
+// one of m's map entries.
+func mapEntryDescriptor(m *descriptorpb.DescriptorProto, typeName string) *descriptorpb.DescriptorProto {
+	parts := strings.Split(strings.TrimPrefix(typeName, "."), ".")
+	name := parts[len(parts)-1]
+	for _, n := range m.GetNestedType() {
+		if n.GetName() == name && n.GetOptions().GetMapEntry() {
+			return n
+		}
+	}
+	return nil
+}
+
+// handleReservedDescriptor converts a message's reserved ranges/names into
+// the same +gunk message.Reserved{} annotation handleReserved emits for
+// the text-parser front-end. ReservedRange.End is exclusive in the
+// descriptor (unlike the inclusive "reserved N to M;" syntax), so it's
+// adjusted back by one to match.
+func (b *builder) handleReservedDescriptor(w *strings.Builder, m *descriptorpb.DescriptorProto) {
+	if len(m.GetReservedRange()) == 0 && len(m.GetReservedName()) == 0 {
+		return
+	}
+	ranges := make([]string, 0, len(m.GetReservedRange()))
+	for _, rg := range m.GetReservedRange() {
+		ranges = append(ranges, fmt.Sprintf("{%d, %d}", rg.GetStart(), rg.GetEnd()-1))
+	}
+	names := make([]string, 0, len(m.GetReservedName()))
+	for _, n := range m.GetReservedName() {
+		names = append(names, fmt.Sprintf("%q", n))
+	}
+
+	b.format(w, 0, nil, "// +gunk message.Reserved{\n")
+	if len(ranges) > 0 {
+		b.format(w, 0, nil, "//     Ranges: [][2]int{%s},\n", strings.Join(ranges, ", "))
+	}
+	if len(names) > 0 {
+		b.format(w, 0, nil, "//     Names: []string{%s},\n", strings.Join(names, ", "))
+	}
+	b.format(w, 0, nil, "// }\n")
+	b.importsUsed["github.com/gunk/opt/message"] = true
+}
+
+// handleExtensionRangeDescriptor converts a message's proto2 "extensions N
+// to M;" ranges into a +gunk message.ExtensionRange{} annotation, so they
+// aren't silently dropped the way they would be going through the
+// text-parser front-end (emicklei/proto doesn't expose them at all).
+func (b *builder) handleExtensionRangeDescriptor(w *strings.Builder, m *descriptorpb.DescriptorProto) {
+	if len(m.GetExtensionRange()) == 0 {
+		return
+	}
+	ranges := make([]string, 0, len(m.GetExtensionRange()))
+	for _, rg := range m.GetExtensionRange() {
+		ranges = append(ranges, fmt.Sprintf("{%d, %d}", rg.GetStart(), rg.GetEnd()-1))
+	}
+	b.format(w, 0, nil, "// +gunk message.ExtensionRange{\n")
+	b.format(w, 0, nil, "//     Ranges: [][2]int{%s},\n", strings.Join(ranges, ", "))
+	b.format(w, 0, nil, "// }\n")
+	b.importsUsed["github.com/gunk/opt/message"] = true
+}
+
+// handleMessageDescriptor is the descriptor-driven counterpart of
+// handleMessage. Unlike handleMessage, it doesn't understand gogoproto
+// field options (customtype, casttype, embed, nullable, jsontag,
+// moretags): those are registered as extensions of gogo's own
+// descriptor.FieldOptions, a different Go type from the
+// descriptorpb.FieldOptions protoc hands every plugin, so there is no
+// extension to look up here - gogoproto annotations only come through
+// convertFile's text-parser front-end.
+func (b *builder) handleMessageDescriptor(m *descriptorpb.DescriptorProto) error {
+	w := &strings.Builder{}
+	b.handleReservedDescriptor(w, m)
+	b.handleExtensionRangeDescriptor(w, m)
+	b.format(w, 0, nil, "type %s struct {\n", m.GetName())
+	for _, f := range m.GetField() {
+		if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			if entry := mapEntryDescriptor(m, f.GetTypeName()); entry != nil {
+				b.handleMapFieldDescriptor(w, f, entry)
+				continue
+			}
+		}
+
+		repeated := f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+
+		typ := b.goTypeFromDescriptor(f)
+		if repeated {
+			typ = "[]" + typ
+		} else if f.GetProto3Optional() {
+			typ = "*" + typ
+		}
+
+		if f.GetDefaultValue() != "" {
+			b.format(w, 1, nil, "// +gunk field.Default(%q)\n", f.GetDefaultValue())
+			b.importsUsed["github.com/gunk/opt/field"] = true
+		}
+
+		tag := fmt.Sprintf("pb:\"%d\" json:\"%s\"", f.GetNumber(), snaker.CamelToSnake(f.GetName()))
+		b.format(w, 1, nil, "%s %s", snaker.ForceCamelIdentifier(f.GetName()), typ)
+		b.format(w, 0, nil, " `%s`\n", tag)
+	}
+	b.format(w, 0, nil, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+
+	for _, e := range m.GetEnumType() {
+		if err := b.handleEnumDescriptor(e); err != nil {
+			return err
+		}
+	}
+	// Non-map nested messages don't have a gunk equivalent of Go's nested
+	// types, so - like handleMessage does for nested enums - they're
+	// hoisted to a top-level declaration of their own; fields that
+	// reference them use the same (unqualified) name.
+	for _, n := range m.GetNestedType() {
+		if n.GetOptions().GetMapEntry() {
+			continue
+		}
+		if err := b.handleMessageDescriptor(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleMapFieldDescriptor writes a map<K, V> field, resolved from the
+// synthetic "key"/"value" fields protoc puts on the map entry message.
+func (b *builder) handleMapFieldDescriptor(w *strings.Builder, f *descriptorpb.FieldDescriptorProto, entry *descriptorpb.DescriptorProto) {
+	var keyField, valueField *descriptorpb.FieldDescriptorProto
+	for _, ef := range entry.GetField() {
+		switch ef.GetName() {
+		case "key":
+			keyField = ef
+		case "value":
+			valueField = ef
+		}
+	}
+	typ := fmt.Sprintf("map[%s]%s", b.goTypeFromDescriptor(keyField), b.goTypeFromDescriptor(valueField))
+	b.format(w, 1, nil, "%s %s", snaker.ForceCamelIdentifier(f.GetName()), typ)
+	b.format(w, 0, nil, " `pb:\"%d\" json:\"%s\"`\n", f.GetNumber(), snaker.CamelToSnake(f.GetName()))
+}
+
+// handleEnumDescriptor is the descriptor-driven counterpart of handleEnum.
+func (b *builder) handleEnumDescriptor(e *descriptorpb.EnumDescriptorProto) error {
+	w := &strings.Builder{}
+	b.format(w, 0, nil, "type %s int\n", e.GetName())
+	b.format(w, 0, nil, "\nconst (\n")
+
+	outputIota := true
+	for i, v := range e.GetValue() {
+		if int(v.GetNumber()) != i {
+			outputIota = false
+		}
+	}
+	for i, v := range e.GetValue() {
+		if !outputIota {
+			b.format(w, 1, nil, "%s %s = %d\n", v.GetName(), e.GetName(), v.GetNumber())
+			continue
+		}
+		if i == 0 {
+			b.format(w, 1, nil, "%s %s = iota\n", v.GetName(), e.GetName())
+		} else {
+			b.format(w, 1, nil, "%s\n", v.GetName())
+		}
+	}
+	b.format(w, 0, nil, ")")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+	return nil
+}
+
+// httpRuleExtension returns the (google.api.http) extension on a method's
+// options, or nil if it isn't set.
+func httpRuleExtension(m *descriptorpb.MethodDescriptorProto) *annotations.HttpRule {
+	if m.GetOptions() == nil {
+		return nil
+	}
+	rule, _ := proto.GetExtension(m.GetOptions(), annotations.E_Http).(*annotations.HttpRule)
+	return rule
+}
+
+// flattenHTTPRule turns an HttpRule into one httpMatch per binding,
+// recursing into AdditionalBindings the same way handleHTTPRule does for
+// the text-parser front-end.
+func flattenHTTPRule(rule *annotations.HttpRule) []httpMatch {
+	var method, url string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, url = "GET", p.Get
+	case *annotations.HttpRule_Put:
+		method, url = "PUT", p.Put
+	case *annotations.HttpRule_Post:
+		method, url = "POST", p.Post
+	case *annotations.HttpRule_Delete:
+		method, url = "DELETE", p.Delete
+	case *annotations.HttpRule_Patch:
+		method, url = "PATCH", p.Patch
+	case *annotations.HttpRule_Custom:
+		method, url = p.Custom.GetKind(), p.Custom.GetPath()
+	}
+
+	var matches []httpMatch
+	if method != "" && url != "" {
+		matches = append(matches, httpMatch{method: method, url: url, body: rule.GetBody()})
+	}
+	for _, extra := range rule.GetAdditionalBindings() {
+		matches = append(matches, flattenHTTPRule(extra)...)
+	}
+	return matches
+}
+
+// handleServiceDescriptor is the descriptor-driven counterpart of
+// handleService.
+func (b *builder) handleServiceDescriptor(s *descriptorpb.ServiceDescriptorProto) error {
+	w := &strings.Builder{}
+	b.format(w, 0, nil, "type %s interface {\n", s.GetName())
+	for i, m := range s.GetMethod() {
+		if i > 0 {
+			b.format(w, 0, nil, "\n")
+		}
+
+		if rule := httpRuleExtension(m); rule != nil {
+			matches := flattenHTTPRule(rule)
+			for _, match := range matches {
+				b.format(w, 1, nil, "// +gunk http.Match{\n")
+				b.format(w, 1, nil, "//     Method: %q,\n", match.method)
+				b.format(w, 1, nil, "//     Path: %q,\n", match.url)
+				if match.body != "" {
+					b.format(w, 1, nil, "//     Body: %q,\n", match.body)
+				}
+				b.format(w, 1, nil, "// }\n")
+			}
+			if len(matches) > 0 {
+				b.importsUsed["github.com/gunk/opt/http"] = true
+			}
+		}
+
+		// Check the empty-parameter case before resolving the Go type: a
+		// request/returns type of google.protobuf.Empty means "no
+		// parameter" here, so it must not add an import for it the way a
+		// plain field reference to Empty would.
+		var requestType, returnsType string
+		if !isEmptyTypeName(m.GetInputType()) {
+			requestType = b.goTypeName(m.GetInputType())
+		}
+		if !isEmptyTypeName(m.GetOutputType()) {
+			returnsType = b.goTypeName(m.GetOutputType())
+		}
+
+		if m.GetClientStreaming() || m.GetServerStreaming() {
+			b.format(w, 1, nil, "// +gunk grpc.Stream{\n")
+			b.format(w, 1, nil, "//     Request: %t,\n", m.GetClientStreaming())
+			b.format(w, 1, nil, "//     Returns: %t,\n", m.GetServerStreaming())
+			b.format(w, 1, nil, "// }\n")
+			b.importsUsed["github.com/gunk/opt/grpc"] = true
+			if m.GetClientStreaming() && requestType != "" {
+				requestType = "chan " + requestType
+			}
+			if m.GetServerStreaming() && returnsType != "" {
+				returnsType = "chan " + returnsType
+			}
+		}
+		b.format(w, 1, nil, "%s(%s) %s\n", m.GetName(), requestType, returnsType)
+	}
+	b.format(w, 0, nil, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+	return nil
+}
+
+// goPackageName derives the single Go package identifier gunk expects from
+// a file descriptor. A proto package is dotted (e.g. "foo.v1") and isn't a
+// valid Go package name on its own, so this prefers the file's go_package
+// option - stripping any "import/path;" prefix, the way protoc-gen-go does
+// - and otherwise falls back to the last component of the proto package.
+func goPackageName(fd *descriptorpb.FileDescriptorProto) string {
+	if gp := fd.GetOptions().GetGoPackage(); gp != "" {
+		if i := strings.LastIndex(gp, ";"); i != -1 {
+			return gp[i+1:]
+		}
+		if i := strings.LastIndex(gp, "/"); i != -1 {
+			return gp[i+1:]
+		}
+		return gp
+	}
+	parts := strings.Split(fd.GetPackage(), ".")
+	return parts[len(parts)-1]
+}
+
+// convertFileDescriptor converts a single fully-resolved FileDescriptorProto
+// to gunk source. It is the descriptor-driven counterpart of convertFile.
+func convertFileDescriptor(fd *descriptorpb.FileDescriptorProto) (string, error) {
+	b := builder{
+		filename:                      fd.GetName(),
+		importsUsed:                   map[string]bool{},
+		translatedWellKnownProtoFiles: map[string]bool{},
+	}
+
+	for _, m := range fd.GetMessageType() {
+		if err := b.handleMessageDescriptor(m); err != nil {
+			return "", err
+		}
+	}
+	for _, e := range fd.GetEnumType() {
+		if err := b.handleEnumDescriptor(e); err != nil {
+			return "", err
+		}
+	}
+	for _, s := range fd.GetService() {
+		if err := b.handleServiceDescriptor(s); err != nil {
+			return "", err
+		}
+	}
+
+	w := &strings.Builder{}
+	fmt.Fprintf(w, "package %s", goPackageName(fd))
+	w.WriteString("\n\n")
+	if imports := b.handleImports(); imports != "" {
+		w.WriteString(imports)
+		w.WriteString("\n")
+	}
+	for _, d := range b.translatedDeclarations {
+		w.WriteString("\n")
+		w.WriteString(d)
+		w.WriteString("\n")
+	}
+
+	result, err := format.Source([]byte(w.String()))
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// RunPlugin implements a protoc plugin: given a CodeGeneratorRequest
+// carrying a fully-resolved set of FileDescriptorProtos (as protoc builds
+// for every protoc-gen-* plugin), it converts every file named in
+// FileToGenerate to gunk. See cmd/protoc-gen-gunk for the binary that
+// drives this over stdin/stdout.
+func RunPlugin(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	resp := &pluginpb.CodeGeneratorResponse{}
+	for _, name := range req.GetFileToGenerate() {
+		var fd *descriptorpb.FileDescriptorProto
+		for _, f := range req.GetProtoFile() {
+			if f.GetName() == name {
+				fd = f
+				break
+			}
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("no descriptor for file %q in request", name)
+		}
+
+		content, err := convertFileDescriptor(fd)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert %q: %v", name, err)
+		}
+
+		outName := strings.TrimSuffix(name, ".proto") + ".gunk"
+		resp.File = append(resp.File, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(outName),
+			Content: proto.String(content),
+		})
+	}
+	return resp, nil
+}
+
+// RunDescriptorSet is the --descriptor_set_in counterpart of Run: instead
+// of parsing .proto files directly, it loads a pre-built
+// FileDescriptorSet (as produced by `protoc --descriptor_set_out=...
+// --include_imports`) from disk and converts every file in it.
+func RunDescriptorSet(path string, overwrite bool) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read descriptor set %q: %v", path, err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("unable to parse descriptor set %q: %v", path, err)
+	}
+
+	req := &pluginpb.CodeGeneratorRequest{ProtoFile: set.GetFile()}
+	for _, fd := range set.GetFile() {
+		req.FileToGenerate = append(req.FileToGenerate, fd.GetName())
+	}
+
+	resp, err := RunPlugin(req)
+	if err != nil {
+		return err
+	}
+	for _, f := range resp.GetFile() {
+		fullpath := filepath.Join(filepath.Dir(path), filepath.Base(f.GetName()))
+		if _, err := os.Stat(fullpath); !os.IsNotExist(err) && !overwrite {
+			return fmt.Errorf("path already exists %q, use --overwrite", fullpath)
+		}
+		if err := ioutil.WriteFile(fullpath, []byte(f.GetContent()), 0644); err != nil {
+			return fmt.Errorf("unable to write to file %q: %v", fullpath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,690 @@
+package convert
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/knq/snaker"
+)
+
+// This file adds the reverse direction to convertFile: instead of proto ->
+// gunk, it goes gunk -> proto, inverting every rule convertFile applies.
+// Since a .gunk file is valid Go source, it's parsed with the standard
+// go/parser rather than anything proto-specific. This is what lets a user
+// migrating to gunk incrementally prove the conversion round-trips: parse
+// a .proto, convert it to .gunk, convert that back to .proto, and diff
+// against the original.
+//
+// TODO(vishen): this only covers what convertFile produces for a "typical"
+// proto3 file (messages, enums, services, the http/stream annotations and
+// the file option annotations); reserved and the gogoproto annotations
+// don't have an inverse here yet, and oneof fields are dropped rather
+// than reconstructed (see oneofWrapperTypeNames/isOneofMarkerInterface).
+
+// protoFieldType is the inverse of builder.goType: it maps the Go types
+// goType produces back to their proto scalar name. Several proto types map
+// to the same Go type (e.g. sint32 and sfixed32 both become int32), so this
+// is necessarily lossy; we pick the most common proto3 spelling.
+var protoFieldType = map[string]string{
+	"bool":    "bool",
+	"string":  "string",
+	"[]byte":  "bytes",
+	"float64": "double",
+	"float32": "float",
+	"int":     "int32",
+	"int32":   "int32",
+	"int64":   "int64",
+	"uint32":  "uint32",
+	"uint64":  "uint64",
+}
+
+// protoBuilder accumulates the proto declarations and file-level bits
+// gathered while walking a parsed .gunk file, mirroring the role builder
+// plays for the proto -> gunk direction.
+type protoBuilder struct {
+	filename string
+
+	translatedDeclarations []string
+
+	pkgName  string
+	fileOpts []string // already-formatted "name = value;" file options
+	imports  []string // proto import paths, e.g. "google/api/annotations.proto"
+
+	// oneofWrapperTypes holds the name of every struct handleOneof
+	// generated as a oneof arm, recognised by its "func (*T) isX_Y() {}"
+	// marker method. handleDecl uses this to skip the wrapper struct
+	// instead of emitting it as a bogus message; the marker interface is
+	// recognised separately by isOneofMarkerInterface.
+	oneofWrapperTypes map[string]bool
+}
+
+// oneofWrapperTypeNames scans f's top-level function declarations for the
+// marker method handleOneof attaches to each oneof arm's wrapper struct
+// ("func (*Foo_Bar) isFoo_Payload() {}": unexported, no params, no
+// results), and returns the set of receiver type names found.
+func oneofWrapperTypeNames(f *ast.File) map[string]bool {
+	types := map[string]bool{}
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Recv == nil || len(fd.Recv.List) != 1 {
+			continue
+		}
+		if ast.IsExported(fd.Name.Name) {
+			continue
+		}
+		if fd.Type.Params.NumFields() != 0 || fd.Type.Results.NumFields() != 0 {
+			continue
+		}
+		recv := fd.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		if ident, ok := recv.(*ast.Ident); ok {
+			types[ident.Name] = true
+		}
+	}
+	return types
+}
+
+// isOneofMarkerInterface reports whether iface has the exact shape
+// handleOneof generates for a oneof's marker interface: a single
+// unexported, niladic, no-results method. No legitimate gunk service
+// method is unexported, so this is unambiguous.
+func isOneofMarkerInterface(iface *ast.InterfaceType) bool {
+	if iface.Methods == nil || len(iface.Methods.List) != 1 {
+		return false
+	}
+	m := iface.Methods.List[0]
+	if len(m.Names) != 1 || ast.IsExported(m.Names[0].Name) {
+		return false
+	}
+	ft, ok := m.Type.(*ast.FuncType)
+	return ok && ft.Params.NumFields() == 0 && ft.Results.NumFields() == 0
+}
+
+// ToProto loads one or more .gunk files or directories of them, and writes
+// out the equivalent .proto file next to each.
+func ToProto(paths []string, overwrite bool) error {
+	for _, path := range paths {
+		if err := runToProto(path, overwrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runToProto(path string, overwrite bool) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return convertGunkFile(path, overwrite)
+	} else if filepath.Ext(path) == ".gunk" {
+		return fmt.Errorf("%s is a directory, should be a gunk file.", path)
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".gunk" {
+			continue
+		}
+		if err := convertGunkFile(filepath.Join(path, f.Name()), overwrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertGunkFile(path string, overwrite bool) error {
+	if filepath.Ext(path) != ".gunk" {
+		return fmt.Errorf("ToProto requires a .gunk file")
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("unable to parse gunk file %q: %v", path, err)
+	}
+
+	filename := filepath.Base(path)
+	fileToWrite := strings.Replace(filename, ".gunk", ".proto", 1)
+	fullpath := filepath.Join(filepath.Dir(path), fileToWrite)
+	if _, err := os.Stat(fullpath); !os.IsNotExist(err) && !overwrite {
+		return fmt.Errorf("path already exists %q, use --overwrite", fullpath)
+	}
+
+	pb := &protoBuilder{
+		filename:          filename,
+		pkgName:           f.Name.Name,
+		imports:           recoverProtoImports(f),
+		oneofWrapperTypes: oneofWrapperTypeNames(f),
+	}
+	for _, decl := range f.Decls {
+		if err := pb.handleDecl(decl); err != nil {
+			return err
+		}
+	}
+
+	// The package doc comment carries the +gunk file.* annotations
+	// convertFile wrote above "package foo". Pull them back out into
+	// proto file options.
+	if f.Doc != nil {
+		if err := pb.handleFileAnnotations(f.Doc); err != nil {
+			return err
+		}
+	}
+
+	w := &strings.Builder{}
+	fmt.Fprintf(w, "syntax = \"proto3\";\n\n")
+	fmt.Fprintf(w, "package %s;\n", pb.pkgName)
+	for _, i := range pb.imports {
+		fmt.Fprintf(w, "import %q;\n", i)
+	}
+	for _, o := range pb.fileOpts {
+		fmt.Fprintf(w, "option %s;\n", o)
+	}
+	for _, d := range pb.translatedDeclarations {
+		w.WriteString("\n")
+		w.WriteString(d)
+		w.WriteString("\n")
+	}
+
+	return ioutil.WriteFile(fullpath, []byte(w.String()), 0644)
+}
+
+// recoverProtoImports recovers the proto import paths handleImports wrote
+// as comments inside the gunk import block - they have no Go equivalent
+// (e.g. "google/api/annotations.proto" for an http.Match annotation), so
+// that's the only place they survive the proto -> gunk conversion. The
+// real Go imports alongside them (github.com/gunk/opt/...) are gunk-only
+// and have nothing to contribute to a .proto file, so they're skipped.
+func recoverProtoImports(f *ast.File) []string {
+	var importDecl *ast.GenDecl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			importDecl = gd
+			break
+		}
+	}
+	if importDecl == nil || !importDecl.Lparen.IsValid() {
+		return nil
+	}
+
+	var imports []string
+	for _, cg := range f.Comments {
+		if cg.Pos() < importDecl.Lparen || cg.End() > importDecl.Rparen {
+			continue
+		}
+		for _, c := range cg.List {
+			line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			path, err := strconv.Unquote(line)
+			if err != nil {
+				continue
+			}
+			imports = append(imports, path)
+		}
+	}
+	return imports
+}
+
+// goTypeExprString renders a type expression the same way goType's inverse
+// needs it: the bare identifier for scalar and custom types, or a
+// recursive map[K]V / []T for the composite ones gunk uses.
+func goTypeExprString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.StarExpr:
+		return goTypeExprString(t.X)
+	case *ast.ArrayType:
+		return "[]" + goTypeExprString(t.Elt)
+	case *ast.MapType:
+		return fmt.Sprintf("map[%s]%s", goTypeExprString(t.Key), goTypeExprString(t.Value))
+	case *ast.ChanType:
+		return goTypeExprString(t.Value)
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
+
+// protoType turns a Go type, as rendered by goTypeExprString, back into a
+// proto type: repeated/map wrappers are peeled off and reapplied as proto
+// syntax, and scalars go through protoFieldType; anything else is assumed
+// to be a reference to another message/enum.
+func protoType(typ string) (protoTyp string, repeated bool) {
+	if strings.HasPrefix(typ, "[]byte") {
+		return "bytes", false
+	}
+	if strings.HasPrefix(typ, "[]") {
+		t, _ := protoType(strings.TrimPrefix(typ, "[]"))
+		return t, true
+	}
+	if strings.HasPrefix(typ, "map[") {
+		return typ, false
+	}
+	if pt, ok := protoFieldType[typ]; ok {
+		return pt, false
+	}
+	if pt, ok := protoWellKnownType[typ]; ok {
+		return pt, false
+	}
+	return typ, false
+}
+
+// protoWellKnownType is the inverse of wellKnownTypes, keyed by the bare
+// Go identifier goTypeExprString renders a well-known type field as. The
+// wrapper types (*string, *int32, ...) aren't included: they render the
+// same as a plain or optional scalar, so there's no way to tell them
+// apart from here.
+var protoWellKnownType = map[string]string{
+	"Time":     "google.protobuf.Timestamp",
+	"Duration": "google.protobuf.Duration",
+	"Empty":    "google.protobuf.Empty",
+	"Struct":   "google.protobuf.Struct",
+	"Any":      "google.protobuf.Any",
+}
+
+// mapTypeParts splits a "map[K]V" string, as produced by goTypeExprString,
+// back into its key and value proto types.
+func mapTypeParts(typ string) (key, value string) {
+	typ = strings.TrimPrefix(typ, "map[")
+	idx := strings.Index(typ, "]")
+	key = typ[:idx]
+	value = typ[idx+1:]
+	keyProto, _ := protoType(key)
+	valueProto, _ := protoType(value)
+	return keyProto, valueProto
+}
+
+// handleDecl dispatches a single top-level Go declaration to the message,
+// enum or service handler it corresponds to.
+func (b *protoBuilder) handleDecl(decl ast.Decl) error {
+	switch d := decl.(type) {
+	case *ast.GenDecl:
+		switch d.Tok {
+		case token.TYPE:
+			for _, spec := range d.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				switch t := ts.Type.(type) {
+				case *ast.StructType:
+					if b.oneofWrapperTypes[ts.Name.Name] {
+						// A oneof arm's wrapper struct: has no message of
+						// its own, see handleMessage's pb:"oneof" case.
+						continue
+					}
+					if err := b.handleMessage(ts.Name.Name, d.Doc, t); err != nil {
+						return err
+					}
+				case *ast.InterfaceType:
+					if isOneofMarkerInterface(t) {
+						// A oneof's marker interface, e.g. IsFoo_Payload:
+						// there's no proto service behind it, see
+						// handleMessage's pb:"oneof" case.
+						continue
+					}
+					if err := b.handleService(ts.Name.Name, d.Doc, t); err != nil {
+						return err
+					}
+				case *ast.Ident:
+					// An enum's underlying "type Foo int" declaration; the
+					// values are emitted from the paired const block below.
+				}
+			}
+		case token.CONST:
+			if err := b.handleEnum(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleMessage converts a gunk struct back into a proto message.
+func (b *protoBuilder) handleMessage(name string, doc *ast.CommentGroup, s *ast.StructType) error {
+	w := &strings.Builder{}
+	writeComment(w, 0, doc)
+	fmt.Fprintf(w, "message %s {\n", name)
+	for _, f := range s.Fields.List {
+		if len(f.Names) == 0 {
+			continue
+		}
+		fieldName := f.Names[0].Name
+
+		if f.Tag != nil {
+			tag := reflect.StructTag(strings.Trim(f.Tag.Value, "`"))
+			if tag.Get("pb") == "oneof" {
+				// The field handleOneof generated for a oneof: its type is
+				// a marker interface, not a proto type, and there's no
+				// inverse of handleOneof yet to recover the original
+				// oneof block from it, so skip it rather than emit a
+				// reference to a nonexistent message.
+				fmt.Fprintf(os.Stderr, "%s: dropping oneof field %q in message %q: reverse conversion doesn't support oneof yet\n", b.filename, fieldName, name)
+				continue
+			}
+		}
+
+		goTyp := goTypeExprString(f.Type)
+		protoTyp, repeated := protoType(goTyp)
+
+		sequence := 0
+		if f.Tag != nil {
+			tag := reflect.StructTag(strings.Trim(f.Tag.Value, "`"))
+			if n, err := strconv.Atoi(tag.Get("pb")); err == nil {
+				sequence = n
+			}
+		}
+
+		writeComment(w, 1, f.Doc)
+		fmt.Fprintf(w, "\t")
+		if repeated {
+			fmt.Fprintf(w, "repeated ")
+		}
+		if strings.HasPrefix(goTyp, "map[") {
+			key, value := mapTypeParts(goTyp)
+			fmt.Fprintf(w, "map<%s, %s> %s = %d;\n", key, value, snaker.CamelToSnake(fieldName), sequence)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s = %d;\n", protoTyp, snaker.CamelToSnake(fieldName), sequence)
+	}
+	fmt.Fprintf(w, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+	return nil
+}
+
+// handleEnum converts a gunk `const ( ... )` block back into a proto
+// enum. Values default to declaration order (the inverse of
+// handleEnum's iota-detection on the way in), but an explicit "= N" on a
+// const spec - as handleEnumDescriptor emits for a non-contiguous enum -
+// takes precedence.
+func (b *protoBuilder) handleEnum(d *ast.GenDecl) error {
+	if len(d.Specs) == 0 {
+		return nil
+	}
+	vs, ok := d.Specs[0].(*ast.ValueSpec)
+	if !ok || len(vs.Names) == 0 {
+		return nil
+	}
+	// The enum's Go type is whatever the first const is declared as, e.g.
+	// "Foo" in "FOO_BAR Foo = iota".
+	enumType, ok := vs.Type.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	w := &strings.Builder{}
+	writeComment(w, 0, d.Doc)
+	fmt.Fprintf(w, "enum %s {\n", enumType.Name)
+	for i, spec := range d.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok || len(vs.Names) == 0 {
+			continue
+		}
+		value := i
+		if len(vs.Values) > 0 {
+			expr, sign := vs.Values[0], 1
+			if u, ok := expr.(*ast.UnaryExpr); ok && u.Op == token.SUB {
+				expr, sign = u.X, -1
+			}
+			if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.INT {
+				if n, err := strconv.Atoi(lit.Value); err == nil {
+					value = sign * n
+				}
+			}
+		}
+
+		writeComment(w, 1, vs.Doc)
+		fmt.Fprintf(w, "\t%s = %d;\n", vs.Names[0].Name, value)
+	}
+	fmt.Fprintf(w, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+	return nil
+}
+
+var httpMatchRe = regexp.MustCompile(`(?s)\+gunk http\.Match\{(.*?)\}`)
+var httpFieldRe = regexp.MustCompile(`(\w+):\s*"((?:[^"\\]|\\.)*)"`)
+var streamRe = regexp.MustCompile(`(?s)\+gunk grpc\.Stream\{(.*?)\}`)
+var streamFieldRe = regexp.MustCompile(`(\w+):\s*(true|false)`)
+
+// handleService converts a gunk interface back into a proto service,
+// recovering the (google.api.http) option from a leading +gunk
+// http.Match{} annotation, and streaming from a +gunk grpc.Stream{}
+// annotation.
+func (b *protoBuilder) handleService(name string, doc *ast.CommentGroup, iface *ast.InterfaceType) error {
+	w := &strings.Builder{}
+	writeComment(w, 0, doc)
+	fmt.Fprintf(w, "service %s {\n", name)
+	for _, m := range iface.Methods.List {
+		if len(m.Names) == 0 {
+			continue
+		}
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok {
+			continue
+		}
+
+		requestType := "google.protobuf.Empty"
+		if ft.Params != nil && len(ft.Params.List) > 0 {
+			requestType = goTypeExprString(ft.Params.List[0].Type)
+		}
+		returnsType := "google.protobuf.Empty"
+		if ft.Results != nil && len(ft.Results.List) > 0 {
+			returnsType = goTypeExprString(ft.Results.List[0].Type)
+		}
+		streamsRequest := ft.Params != nil && len(ft.Params.List) > 0 && isChanExpr(ft.Params.List[0].Type)
+		streamsReturns := ft.Results != nil && len(ft.Results.List) > 0 && isChanExpr(ft.Results.List[0].Type)
+		text := commentText(m.Doc)
+		if sm := streamRe.FindStringSubmatch(text); sm != nil {
+			for _, f := range streamFieldRe.FindAllStringSubmatch(sm[1], -1) {
+				switch f[1] {
+				case "Request":
+					streamsRequest = f[2] == "true"
+				case "Returns":
+					streamsReturns = f[2] == "true"
+				}
+			}
+		}
+		if streamsRequest {
+			requestType = "stream " + requestType
+		}
+		if streamsReturns {
+			returnsType = "stream " + returnsType
+		}
+
+		if plain := stripGunkAnnotations(text); plain != "" {
+			for _, line := range strings.Split(plain, "\n") {
+				fmt.Fprintf(w, "\t//%s\n", line)
+			}
+		}
+
+		fmt.Fprintf(w, "\trpc %s(%s) returns (%s)", m.Names[0].Name, requestType, returnsType)
+		if opts := httpRuleOptionText(text); opts != "" {
+			fmt.Fprintf(w, " {\n%s\t}", opts)
+		}
+		fmt.Fprintf(w, ";\n")
+	}
+	fmt.Fprintf(w, "}")
+	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
+	return nil
+}
+
+// stripGunkAnnotations removes the +gunk http.Match{}/grpc.Stream{} blocks
+// from a doc comment's text, leaving whatever plain description the
+// original .proto comment carried.
+func stripGunkAnnotations(text string) string {
+	text = httpMatchRe.ReplaceAllString(text, "")
+	text = streamRe.ReplaceAllString(text, "")
+	return strings.TrimSpace(text)
+}
+
+func isChanExpr(expr ast.Expr) bool {
+	_, ok := expr.(*ast.ChanType)
+	return ok
+}
+
+// httpRuleOptionText recovers the body of a (google.api.http) option from
+// the +gunk http.Match{} annotation(s) convertFile wrote into the
+// method's doc comment. A method can carry more than one - handleService
+// emits one per flattened HttpRule binding - so the first becomes the
+// primary binding and the rest are nested as additional_bindings.
+func httpRuleOptionText(doc string) string {
+	matches := httpMatchRe.FindAllStringSubmatch(doc, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	w := &strings.Builder{}
+	fmt.Fprintf(w, "\t\toption (google.api.http) = {\n")
+	for i, match := range matches {
+		fields := map[string]string{}
+		for _, f := range httpFieldRe.FindAllStringSubmatch(match[1], -1) {
+			fields[f[1]] = f[2]
+		}
+		method, ok := fields["Method"]
+		if !ok {
+			continue
+		}
+
+		indent := "\t\t\t"
+		if i > 0 {
+			fmt.Fprintf(w, "\t\t\tadditional_bindings {\n")
+			indent = "\t\t\t\t"
+		}
+		switch verb := strings.ToLower(method); verb {
+		case "get", "put", "post", "delete", "patch":
+			fmt.Fprintf(w, "%s%s: \"%s\"\n", indent, verb, fields["Path"])
+		default:
+			fmt.Fprintf(w, "%scustom { kind: \"%s\" path: \"%s\" }\n", indent, method, fields["Path"])
+		}
+		if body, ok := fields["Body"]; ok {
+			fmt.Fprintf(w, "%sbody: \"%s\"\n", indent, body)
+		}
+		if i > 0 {
+			fmt.Fprintf(w, "\t\t\t}\n")
+		}
+	}
+	fmt.Fprintf(w, "\t\t};\n")
+	return w.String()
+}
+
+// handleFileAnnotations recovers the +gunk file.*/file/java.*/etc.
+// annotations from the package doc comment back into proto file options.
+// This only understands the handful of annotations handlePackage emits;
+// anything else is left alone.
+func (b *protoBuilder) handleFileAnnotations(doc *ast.CommentGroup) error {
+	for _, c := range doc.List {
+		line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if !strings.HasPrefix(line, "+gunk ") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "+gunk ")
+
+		dot := strings.Index(line, ".")
+		paren := strings.Index(line, "(")
+		if dot == -1 || paren == -1 || paren < dot {
+			continue
+		}
+		pkg := line[:dot]
+		fn := line[dot+1 : paren]
+		arg := strings.TrimSuffix(line[paren+1:], ")")
+
+		name, ok := fileAnnotationProtoName(pkg, fn)
+		if !ok {
+			continue
+		}
+		if arg == "" {
+			b.fileOpts = append(b.fileOpts, fmt.Sprintf("%s = true", name))
+		} else {
+			b.fileOpts = append(b.fileOpts, fmt.Sprintf("%s = %s", name, arg))
+		}
+	}
+	return nil
+}
+
+// fileAnnotationProtoName is the inverse of handlePackage's pkgOpts
+// switch: it maps a gunk annotation's package and function name back to
+// the proto file option it came from.
+func fileAnnotationProtoName(pkg, fn string) (string, bool) {
+	switch pkg + "." + fn {
+	case "file.Deprecated":
+		return "deprecated", true
+	case "file.OptimizeFor":
+		return "optimize_for", true
+	case "java.Package":
+		return "java_package", true
+	case "java.OuterClassname":
+		return "java_outer_classname", true
+	case "java.MultipleFiles":
+		return "java_multiple_files", true
+	case "java.StringCheckUtf8":
+		return "java_string_check_utf8", true
+	case "java.GenericServices":
+		return "java_generic_services", true
+	case "csharp.Namespace":
+		return "csharp_namespace", true
+	case "objc.ClassPrefix":
+		return "objc_class_prefix", true
+	case "php.GenericServices":
+		return "php_generic_services", true
+	case "cc.GenericServices":
+		return "cc_generic_services", true
+	case "cc.EnableArenas":
+		return "cc_enable_arenas", true
+	default:
+		return "", false
+	}
+}
+
+func commentText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	return doc.Text()
+}
+
+// writeComment writes a Go doc comment back out as a leading proto
+// comment, skipping over any +gunk annotation lines and blocks. Those
+// annotations are not carried into the proto output.
+func writeComment(w *strings.Builder, indent int, doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+	skipping := false
+	for _, c := range doc.List {
+		line := strings.TrimPrefix(c.Text, "//")
+		trimmed := strings.TrimSpace(line)
+		if skipping {
+			if trimmed == "}" {
+				skipping = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "+gunk") {
+			if strings.HasSuffix(trimmed, "{") {
+				skipping = true
+			}
+			continue
+		}
+		for i := 0; i < indent; i++ {
+			w.WriteString("\t")
+		}
+		fmt.Fprintf(w, "//%s\n", line)
+	}
+}